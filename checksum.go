@@ -0,0 +1,97 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ChecksumAlgo identifies a digest algorithm usable with WithChecksum.
+type ChecksumAlgo int
+
+const (
+	// ChecksumSHA256 verifies downloads against a SHA-256 digest.
+	ChecksumSHA256 ChecksumAlgo = iota
+	// ChecksumMD5 verifies downloads against an MD5 digest.
+	ChecksumMD5
+)
+
+func (a ChecksumAlgo) new() hash.Hash {
+	if a == ChecksumMD5 {
+		return md5.New()
+	}
+	return sha256.New()
+}
+
+// checksumConfig is the resolved state of a WithChecksum or WithSHA256URL
+// option.
+type checksumConfig struct {
+	algo ChecksumAlgo
+	// expectedHex is a literal hex digest, set by WithChecksum.
+	expectedHex string
+	// digestURL, set by WithSHA256URL, is fetched after the download to
+	// obtain the expected digest.
+	digestURL string
+}
+
+// verify checks that the file at path matches cs's expected digest,
+// fetching it from cs.digestURL first if cs was built from a URL.
+func (cs *checksumConfig) verify(client *http.Client, path string) error {
+	expected := cs.expectedHex
+	if cs.digestURL != "" {
+		digest, err := fetchDigest(client, cs.digestURL)
+		if err != nil {
+			return err
+		}
+		expected = digest
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := cs.algo.new()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(got, expected) {
+		return fmt.Errorf("%w for %s: got %s, want %s", ErrChecksumMismatch, path, got, expected)
+	}
+	return nil
+}
+
+// fetchDigest GETs url and extracts the digest from its body. Digest
+// files conventionally contain either a bare hex digest or a digest
+// followed by the file name (sha256sum(1) format); either form is
+// accepted.
+func fetchDigest(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrDownloadFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &ErrHTTPStatus{Code: resp.StatusCode}
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty digest response from %s", url)
+	}
+	return fields[0], nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChecksumConfigVerify(t *testing.T) {
+	content := []byte("fake mmdb content")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	path := writeTempFile(t, content)
+	cs := &checksumConfig{algo: ChecksumSHA256, expectedHex: digest}
+	if err := cs.verify(http.DefaultClient, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChecksumConfigVerifyMismatch(t *testing.T) {
+	path := writeTempFile(t, []byte("fake mmdb content"))
+	cs := &checksumConfig{algo: ChecksumSHA256, expectedHex: "0000000000000000000000000000000000000000000000000000000000000"}
+	err := cs.verify(http.DefaultClient, path)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestChecksumConfigVerifyFromDigestURL(t *testing.T) {
+	content := []byte("fake mmdb content")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// sha256sum(1)-style output: digest, whitespace, file name.
+		w.Write([]byte(digest + "  db.mmdb\n"))
+	}))
+	defer server.Close()
+
+	path := writeTempFile(t, content)
+	cs := &checksumConfig{algo: ChecksumSHA256, digestURL: server.URL}
+	if err := cs.verify(http.DefaultClient, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchDigestStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := fetchDigest(http.DefaultClient, server.URL)
+	var statusErr *ErrHTTPStatus
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected *ErrHTTPStatus, got %v", err)
+	}
+	if statusErr.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", statusErr.Code)
+	}
+}
+
+func TestChecksumAlgoMD5(t *testing.T) {
+	content := []byte("fake mmdb content")
+	path := writeTempFile(t, content)
+	h := ChecksumMD5.new()
+	h.Write(content)
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	cs := &checksumConfig{algo: ChecksumMD5, expectedHex: digest}
+	if err := cs.verify(http.DefaultClient, path); err != nil {
+		t.Fatal(err)
+	}
+}
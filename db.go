@@ -5,16 +5,16 @@
 package freegeoip
 
 import (
-	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"sync"
 	"time"
 
@@ -41,10 +41,27 @@ type DB struct {
 	notifyError chan error        // Notify when an error occurs.
 	closed      bool              // Mark this db as closed.
 	lastUpdated time.Time         // Last time the db was updated.
+	md5sum      string            // MD5 of the currently loaded db file.
 	mu          sync.RWMutex      // Protects all the above.
 
 	updateInterval   time.Duration // Update interval.
 	maxRetryInterval time.Duration // Max retry interval in case of failure.
+
+	etag         string // ETag of the last downloaded db, if the origin sent one.
+	lastModified string // Last-Modified of the last downloaded db, if the origin sent one.
+	sha256sum    string // SHA-256 of the last downloaded db content.
+
+	httpClient    *http.Client    // HTTP client for update requests; defaults to http.DefaultClient.
+	archiveFormat Format          // Archive format override; FormatAuto detects it from magic bytes.
+	checksum      *checksumConfig // Expected digest for downloaded files, if WithChecksum/WithSHA256URL was given.
+	collector     *Collector      // Prometheus metrics sink, if WithCollector was given.
+
+	// checkUpdate and fetchUpdate implement the update protocol for the
+	// source the db was opened from. OpenURL wires up the plain HTTP
+	// HEAD+GET protocol; OpenMaxMindURL wires up the metadata +
+	// direct-download protocol used by MaxMind's account-based endpoints.
+	checkUpdate func(url string) (bool, error)
+	fetchUpdate func(url string) (string, error)
 }
 
 // Open creates and initializes a DB from a local file.
@@ -73,7 +90,11 @@ func Open(dsn string) (db *DB, err error) {
 
 // OpenURL creates and initializes a DB from a remote file.
 // It automatically downloads and updates the file in background.
-func OpenURL(url string, updateInterval, maxRetryInterval time.Duration) (db *DB, err error) {
+//
+// By default the archive format is auto-detected and plain http.DefaultClient
+// is used; pass WithHTTPClient, WithArchiveFormat, WithChecksum or
+// WithSHA256URL to override that.
+func OpenURL(url string, updateInterval, maxRetryInterval time.Duration, opts ...Option) (db *DB, err error) {
 	db = &DB{
 		file:             defaultDB,
 		notifyQuit:       make(chan struct{}),
@@ -82,6 +103,12 @@ func OpenURL(url string, updateInterval, maxRetryInterval time.Duration) (db *DB
 		updateInterval:   updateInterval,
 		maxRetryInterval: maxRetryInterval,
 	}
+	for _, opt := range opts {
+		opt(db)
+	}
+	db.checkUpdate = db.needUpdate
+	db.fetchUpdate = db.download
+	db.loadMeta() // Optional, might fail; restores etag/last-modified/sha256 across restarts.
 	db.openFile() // Optional, might fail.
 	go db.autoUpdate(url)
 	err = db.watchFile()
@@ -92,6 +119,39 @@ func OpenURL(url string, updateInterval, maxRetryInterval time.Duration) (db *DB
 	return db, nil
 }
 
+// OpenMaxMindURL creates and initializes a DB from a MaxMind account-based
+// update endpoint, identified by accountID, licenseKey and editionID (e.g.
+// "GeoLite2-City"). It automatically downloads and updates the file in
+// background, using MaxMind's metadata + direct-download protocol instead
+// of the plain HTTP HEAD+GET used by OpenURL.
+func OpenMaxMindURL(accountID int, licenseKey, editionID string, updateInterval, maxRetryInterval time.Duration, opts ...Option) (db *DB, err error) {
+	db = &DB{
+		file:             maxMindDefaultDB(editionID),
+		notifyQuit:       make(chan struct{}),
+		notifyOpen:       make(chan string, 1),
+		notifyError:      make(chan error, 1),
+		updateInterval:   updateInterval,
+		maxRetryInterval: maxRetryInterval,
+	}
+	for _, opt := range opts {
+		opt(db)
+	}
+	db.checkUpdate = func(url string) (bool, error) {
+		return db.maxMindNeedUpdate(accountID, licenseKey, editionID)
+	}
+	db.fetchUpdate = func(url string) (string, error) {
+		return db.maxMindDownload(accountID, licenseKey, editionID)
+	}
+	db.openFile() // Optional, might fail.
+	go db.autoUpdate(editionID)
+	err = db.watchFile()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("fsnotify failed for %s: %s", db.file, err)
+	}
+	return db, nil
+}
+
 func (db *DB) watchFile() error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -105,19 +165,34 @@ func (db *DB) watchFile() error {
 	return watcher.Watch(dbdir)
 }
 
+// watchDebounce is how long watchEvents waits after the last fsnotify
+// event before reloading, so a burst of writes to the same file (e.g. a
+// rename-into-place followed by a chmod) triggers a single reload.
+const watchDebounce = 500 * time.Millisecond
+
 func (db *DB) watchEvents(watcher *fsnotify.Watcher) {
+	timer := time.NewTimer(watchDebounce)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	var pending bool
 	for {
 		select {
 		case ev := <-watcher.Event:
 			if ev.Name == db.file && (ev.IsCreate() || ev.IsModify()) {
-				db.openFile()
+				pending = true
+				timer.Reset(watchDebounce)
 			}
 		case <-watcher.Error:
+		case <-timer.C:
+			if pending {
+				pending = false
+				db.openFile()
+			}
 		case <-db.notifyQuit:
 			watcher.Close()
 			return
 		}
-		time.Sleep(time.Second) // Suppress high-rate events.
 	}
 }
 
@@ -130,29 +205,50 @@ func (db *DB) openFile() error {
 	if err != nil {
 		return err
 	}
-	db.setReader(reader, stat.ModTime())
+	sum, err := fileMD5(db.file)
+	if err != nil {
+		return err
+	}
+	db.setReader(reader, stat.ModTime(), sum)
 	return nil
 }
 
+// newReader opens dbfile and decodes it into a maxminddb.Reader, picking
+// a sourceOpener for its archive format (see Format and WithArchiveFormat).
 func (db *DB) newReader(dbfile string) (*maxminddb.Reader, error) {
-	f, err := os.Open(dbfile)
+	opener, err := db.sourceOpener(dbfile)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	gzf, err := gzip.NewReader(f)
+	return opener.Open(dbfile)
+}
+
+// openMMDBFile is the shared implementation behind DB.newReader and
+// MultiDB's per-edition loader, for callers that don't carry per-DB
+// format overrides and just want auto-detection.
+func openMMDBFile(dbfile string) (*maxminddb.Reader, error) {
+	opener, err := detectOpener(dbfile)
 	if err != nil {
 		return nil, err
 	}
-	defer gzf.Close()
-	b, err := ioutil.ReadAll(gzf)
+	return opener.Open(dbfile)
+}
+
+// fileMD5 returns the hex-encoded MD5 sum of the file at name.
+func fileMD5(name string) (string, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return maxminddb.FromBytes(b)
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func (db *DB) setReader(reader *maxminddb.Reader, modtime time.Time) {
+func (db *DB) setReader(reader *maxminddb.Reader, modtime time.Time, md5sum string) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	if db.closed {
@@ -164,84 +260,143 @@ func (db *DB) setReader(reader *maxminddb.Reader, modtime time.Time) {
 	}
 	db.reader = reader
 	db.lastUpdated = modtime.UTC()
+	db.md5sum = md5sum
+	db.collector.reloadOK(db.lastUpdated)
 	select {
 	case db.notifyOpen <- db.file:
 	default:
 	}
 }
 
+// autoUpdate drives runUpdate on a time.Ticker at db.updateInterval,
+// tightening to an exponential backoff (capped at db.maxRetryInterval)
+// whenever an update fails with a retryable error.
 func (db *DB) autoUpdate(url string) {
+	ticker := time.NewTicker(db.updateInterval)
+	defer ticker.Stop()
+
 	var sleep time.Duration
 	var retrying bool
 	for {
 		err := db.runUpdate(url)
-		if err != nil {
-			db.sendError(fmt.Errorf("Database update failed: %s", err))
-			if !retrying {
-				retrying = true
-				sleep = 5 * time.Second
-			} else {
-				sleep *= 2
-				if sleep > db.maxRetryInterval {
-					sleep = db.maxRetryInterval
-				}
-			}
-		} else {
+		switch {
+		case err == nil:
+			retrying = false
+			sleep = db.updateInterval
+		case isPermanent(err):
+			// Permanent errors (e.g. a 4xx response) won't be fixed by
+			// retrying sooner, so fall back to the normal update
+			// interval instead of tightening the backoff loop.
+			db.sendError(fmt.Errorf("Database update failed: %w", err))
 			retrying = false
 			sleep = db.updateInterval
+		case !retrying:
+			db.sendError(fmt.Errorf("Database update failed: %w", err))
+			retrying = true
+			sleep = 5 * time.Second
+		default:
+			db.sendError(fmt.Errorf("Database update failed: %w", err))
+			sleep *= 2
+			if sleep > db.maxRetryInterval {
+				sleep = db.maxRetryInterval
+			}
 		}
+		ticker.Reset(sleep)
 		select {
 		case <-db.notifyQuit:
 			return
-		case <-time.After(sleep):
-			// Sleep till time for the next update attempt.
+		case <-ticker.C:
+			// Time for the next update attempt.
 		}
 	}
 }
 
+// isPermanent reports whether err identifies a failure that retrying
+// won't fix, such as a 4xx response to a MaxMind request.
+func isPermanent(err error) bool {
+	te, ok := err.(temporaryError)
+	return ok && !te.Temporary()
+}
+
 func (db *DB) runUpdate(url string) error {
-	yes, err := db.needUpdate(url)
+	yes, err := db.checkUpdate(url)
 	if err != nil {
+		db.collector.reloadError()
 		return err
 	}
 	if !yes {
 		return nil
 	}
-	tmpfile, err := db.download(url)
+	tmpfile, err := db.fetchUpdate(url)
 	if err != nil {
+		db.collector.reloadError()
 		return err
 	}
+	if tmpfile == "" {
+		// The download turned out to be a no-op (e.g. a 304, or content
+		// that hashed the same as what's already loaded).
+		return nil
+	}
 	err = db.renameFile(tmpfile)
 	if err != nil {
 		// Cleanup the tempfile if renaming failed.
 		os.RemoveAll(tmpfile)
+		db.collector.reloadError()
 	}
 	return err
 }
 
+// needUpdate issues a conditional HEAD request using the ETag/Last-Modified
+// recorded from the last successful download, so a server that honors
+// conditional requests can answer with a cheap 304 instead of us having to
+// compare Content-Length (which breaks the moment the origin switches to
+// chunked transfer, or two unrelated builds coincidentally share a size).
 func (db *DB) needUpdate(url string) (bool, error) {
-	stat, err := os.Stat(db.file)
-	if err != nil {
+	if _, err := os.Stat(db.file); err != nil {
 		return true, nil // Local db is missing, must be downloaded.
 	}
-	resp, err := http.Head(url)
+	req, err := http.NewRequest("HEAD", url, nil)
 	if err != nil {
 		return false, err
 	}
+	db.setConditionalHeaders(req)
+	resp, err := db.httpClientOrDefault().Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrDownloadFailed, err)
+	}
 	defer resp.Body.Close()
-	size, err := strconv.Atoi(resp.Header.Get("Content-Length"))
-	if stat.Size() != int64(size) {
-		return true, nil
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, &ErrHTTPStatus{Code: resp.StatusCode}
 	}
-	return false, nil
+	return true, nil
 }
 
+// download fetches url and writes it to a tempfile, hashing the body with
+// SHA-256 as it streams so a server that ignores conditional headers (or
+// lies about them) can still be caught before a no-op rename. The ETag,
+// Last-Modified and SHA-256 of a successful download are persisted to the
+// db's sidecar .meta file so a restart doesn't force a redownload.
 func (db *DB) download(url string) (tmpfile string, err error) {
-	resp, err := http.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return "", err
 	}
+	db.setConditionalHeaders(req)
+	resp, err := db.httpClientOrDefault().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrDownloadFailed, err)
+	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &ErrHTTPStatus{Code: resp.StatusCode}
+	}
+
 	tmpfile = filepath.Join(os.TempDir(),
 		fmt.Sprintf("_freegeoip.%d.db.gz", time.Now().UnixNano()))
 	f, err := os.Create(tmpfile)
@@ -249,13 +404,65 @@ func (db *DB) download(url string) (tmpfile string, err error) {
 		return "", err
 	}
 	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
+
+	start := time.Now()
+	h := sha256.New()
+	n, err := io.Copy(f, io.TeeReader(resp.Body, h))
+	db.collector.downloaded(n, time.Since(start))
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	if db.checksum != nil {
+		err = db.checksum.verify(db.httpClientOrDefault(), tmpfile)
+		if err != nil {
+			os.Remove(tmpfile)
+			return "", err
+		}
+	}
+
+	db.mu.RLock()
+	unchanged := sum == db.sha256sum
+	db.mu.RUnlock()
+	if unchanged {
+		os.Remove(tmpfile)
+		return "", nil
+	}
+
+	db.mu.Lock()
+	db.sha256sum = sum
+	db.etag = resp.Header.Get("ETag")
+	db.lastModified = resp.Header.Get("Last-Modified")
+	db.mu.Unlock()
+	err = db.saveMeta()
 	if err != nil {
 		return "", err
 	}
 	return tmpfile, nil
 }
 
+func (db *DB) setConditionalHeaders(req *http.Request) {
+	db.mu.RLock()
+	etag, lastModified := db.etag, db.lastModified
+	db.mu.RUnlock()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// httpClientOrDefault returns the *http.Client configured via
+// WithHTTPClient, or http.DefaultClient if none was given.
+func (db *DB) httpClientOrDefault() *http.Client {
+	if db.httpClient != nil {
+		return db.httpClient
+	}
+	return http.DefaultClient
+}
+
 func (db *DB) makeDir() (dbdir string, err error) {
 	dbdir = filepath.Dir(db.file)
 	_, err = os.Stat(dbdir)
@@ -329,12 +536,7 @@ func (db *DB) sendError(err error) {
 // See https://godoc.org/github.com/oschwald/maxminddb-golang#Reader.Lookup
 // for details.
 func (db *DB) Lookup(addr net.IP, result interface{}) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	if db.reader != nil {
-		return db.reader.Lookup(addr, result)
-	}
-	return ErrUnavailable
+	return db.lookup(addr, result)
 }
 
 // Close the database.
@@ -0,0 +1,50 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrDownloadFailed wraps failures while fetching a database update
+	// over HTTP, such as a connection refused or a TLS handshake
+	// failure, as opposed to an unexpected status code (ErrHTTPStatus).
+	ErrDownloadFailed = errors.New("freegeoip: database download failed")
+
+	// ErrChecksumMismatch is returned by the WithChecksum/WithSHA256URL
+	// verification when a downloaded file's digest doesn't match what
+	// was expected.
+	ErrChecksumMismatch = errors.New("freegeoip: checksum mismatch")
+
+	// ErrArchiveInvalid is returned when a downloaded archive (tar.gz or
+	// zip) can't be parsed, or doesn't contain a .mmdb member.
+	ErrArchiveInvalid = errors.New("freegeoip: invalid database archive")
+)
+
+// temporaryError is implemented by errors that know whether retrying the
+// operation that produced them is worth attempting again.
+type temporaryError interface {
+	Temporary() bool
+}
+
+// ErrHTTPStatus reports an unexpected HTTP response status from a
+// database update endpoint. Use errors.As to recover the status code,
+// e.g. to give up on 401/403 instead of retrying.
+type ErrHTTPStatus struct {
+	Code int
+}
+
+func (e *ErrHTTPStatus) Error() string {
+	return fmt.Sprintf("freegeoip: unexpected HTTP status: %s", http.StatusText(e.Code))
+}
+
+// Temporary reports whether the request is worth retrying. 4xx responses
+// (bad credentials, unknown edition, etc.) are not.
+func (e *ErrHTTPStatus) Temporary() bool {
+	return e.Code < 400 || e.Code >= 500
+}
@@ -0,0 +1,214 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Format identifies the archive/container format of a database file
+// downloaded by OpenURL.
+type Format int
+
+const (
+	// FormatAuto auto-detects the format from magic bytes. It's the
+	// default when no WithArchiveFormat option is given.
+	FormatAuto Format = iota
+	// FormatRaw is a plain, uncompressed .mmdb file.
+	FormatRaw
+	// FormatGzip is a single .mmdb file compressed with gzip, the format
+	// OpenURL has always stored locally.
+	FormatGzip
+	// FormatTarGz is a (usually gzip-compressed) tar archive containing
+	// a .mmdb member, the format MaxMind ships its GeoLite2/GeoIP2
+	// bundles in.
+	FormatTarGz
+	// FormatZip is a zip archive containing a .mmdb member.
+	FormatZip
+)
+
+// sourceOpener decodes a database file of a particular archive format
+// into a maxminddb.Reader.
+type sourceOpener interface {
+	Open(path string) (*maxminddb.Reader, error)
+}
+
+// sourceOpener picks the opener for path: db.archiveFormat if set
+// explicitly via WithArchiveFormat, otherwise whatever detectOpener
+// sniffs from the file's magic bytes.
+func (db *DB) sourceOpener(path string) (sourceOpener, error) {
+	switch db.archiveFormat {
+	case FormatRaw:
+		return rawOpener{}, nil
+	case FormatGzip:
+		return gzipOpener{}, nil
+	case FormatTarGz:
+		return tarGzOpener{}, nil
+	case FormatZip:
+		return zipOpener{}, nil
+	default:
+		return detectOpener(path)
+	}
+}
+
+// detectOpener sniffs path's magic bytes to pick a sourceOpener: 1f 8b
+// for gzip (peeking the decompressed stream for tar's "ustar" magic at
+// offset 257 to tell a bare gzip-compressed .mmdb from a tar.gz bundle),
+// 50 4b for zip, tar's "ustar" at offset 257 of the raw file for an
+// uncompressed tar, otherwise a raw mmdb file.
+func detectOpener(path string) (sourceOpener, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gzr.Close()
+		br := bufio.NewReaderSize(gzr, 512)
+		header, _ := br.Peek(262)
+		if len(header) >= 262 && string(header[257:262]) == "ustar" {
+			return tarGzOpener{}, nil
+		}
+		return gzipOpener{}, nil
+	case len(magic) >= 2 && magic[0] == 0x50 && magic[1] == 0x4b:
+		return zipOpener{}, nil
+	default:
+		header := make([]byte, 5)
+		if _, err := f.ReadAt(header, 257); err == nil && string(header) == "ustar" {
+			return tarGzOpener{}, nil
+		}
+		return rawOpener{}, nil
+	}
+}
+
+// rawOpener decodes a plain, uncompressed .mmdb file.
+type rawOpener struct{}
+
+func (rawOpener) Open(path string) (*maxminddb.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return maxminddb.FromBytes(b)
+}
+
+// gzipOpener decodes a single .mmdb file compressed with gzip.
+type gzipOpener struct{}
+
+func (gzipOpener) Open(path string) (*maxminddb.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	b, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		return nil, err
+	}
+	return maxminddb.FromBytes(b)
+}
+
+// tarGzOpener decodes a (possibly gzip-compressed) tar archive, reading
+// its first .mmdb member.
+type tarGzOpener struct{}
+
+func (tarGzOpener) Open(path string) (*maxminddb.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	gzr, err := gzip.NewReader(f)
+	if err == nil {
+		defer gzr.Close()
+		r = gzr
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: no .mmdb file found in tar archive %s", ErrArchiveInvalid, path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrArchiveInvalid, err)
+		}
+		if filepath.Ext(hdr.Name) != ".mmdb" {
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		return maxminddb.FromBytes(b)
+	}
+}
+
+// zipOpener decodes a zip archive, reading its first .mmdb member.
+type zipOpener struct{}
+
+func (zipOpener) Open(path string) (*maxminddb.Reader, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrArchiveInvalid, err)
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		if filepath.Ext(zf.Name) != ".mmdb" {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		return maxminddb.FromBytes(b)
+	}
+	return nil, fmt.Errorf("%w: no .mmdb file found in zip archive %s", ErrArchiveInvalid, path)
+}
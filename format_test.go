@@ -0,0 +1,138 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, b []byte) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "format-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	if _, err := gzw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarBytes(t *testing.T, name string, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipBytes(t *testing.T, name string, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectOpener(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want sourceOpener
+	}{
+		{"raw", []byte("not an archive"), rawOpener{}},
+		{"gzip of a bare file", gzipBytes(t, []byte("fake mmdb content")), gzipOpener{}},
+		{"gzip of a tar bundle", gzipBytes(t, tarBytes(t, "GeoLite2-ASN.mmdb", []byte("fake mmdb content"))), tarGzOpener{}},
+		{"bare tar bundle", tarBytes(t, "GeoLite2-ASN.mmdb", []byte("fake mmdb content")), tarGzOpener{}},
+		{"zip bundle", zipBytes(t, "GeoLite2-ASN.mmdb", []byte("fake mmdb content")), zipOpener{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := writeTempFile(t, c.data)
+			got, err := detectOpener(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("detectOpener(%s) = %#v, want %#v", c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestTarGzOpenerNoMmdbMember(t *testing.T) {
+	path := writeTempFile(t, gzipBytes(t, tarBytes(t, "readme.txt", []byte("hello"))))
+	_, err := tarGzOpener{}.Open(path)
+	if !errors.Is(err, ErrArchiveInvalid) {
+		t.Fatalf("expected ErrArchiveInvalid, got %v", err)
+	}
+}
+
+func TestZipOpenerNoMmdbMember(t *testing.T) {
+	path := writeTempFile(t, zipBytes(t, "readme.txt", []byte("hello")))
+	_, err := zipOpener{}.Open(path)
+	if !errors.Is(err, ErrArchiveInvalid) {
+		t.Fatalf("expected ErrArchiveInvalid, got %v", err)
+	}
+}
+
+func TestZipOpenerInvalidArchive(t *testing.T) {
+	path := writeTempFile(t, []byte("not a zip file at all"))
+	_, err := zipOpener{}.Open(path)
+	if !errors.Is(err, ErrArchiveInvalid) {
+		t.Fatalf("expected ErrArchiveInvalid, got %v", err)
+	}
+}
+
+func TestSourceOpenerHonorsArchiveFormatOverride(t *testing.T) {
+	// A gzip-of-a-tar file would auto-detect as tarGzOpener; forcing
+	// FormatGzip should skip detection entirely.
+	path := writeTempFile(t, gzipBytes(t, tarBytes(t, "GeoLite2-ASN.mmdb", []byte("fake mmdb content"))))
+	db := &DB{archiveFormat: FormatGzip}
+	opener, err := db.sourceOpener(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := opener.(gzipOpener); !ok {
+		t.Fatalf("sourceOpener() = %#v, want gzipOpener", opener)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// lookup is the shared implementation behind Lookup and LookupAddr: it
+// acquires db.mu for the duration of a single record decode and reports
+// the result to db.collector.
+func (db *DB) lookup(ip net.IP, result interface{}) (err error) {
+	start := time.Now()
+	defer func() { db.collector.lookup(err, time.Since(start)) }()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.reader != nil {
+		return db.reader.Lookup(ip, result)
+	}
+	return ErrUnavailable
+}
+
+// LookupAddr is the net/netip equivalent of Lookup, for callers that
+// already hold addresses as netip.Addr (e.g. from a net.Conn's
+// RemoteAddr on a modern, IPv6-aware server) and want to avoid the
+// net.IP conversion.
+func (db *DB) LookupAddr(addr netip.Addr, result interface{}) error {
+	return db.lookup(net.IP(addr.AsSlice()), result)
+}
+
+// countryCodeRecord decodes only the country ISO code, the small subset
+// of a City/Country database's schema that LookupCountryCode needs.
+type countryCodeRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// LookupCountryCode returns the ISO 3166-1 alpha-2 country code for addr,
+// or "" if the database has no country data for it.
+func (db *DB) LookupCountryCode(addr netip.Addr) (string, error) {
+	var rec countryCodeRecord
+	if err := db.LookupAddr(addr, &rec); err != nil {
+		return "", err
+	}
+	return rec.Country.ISOCode, nil
+}
+
+// asnRecord decodes only the autonomous system number, the subset of an
+// ASN database's schema that LookupASN needs.
+type asnRecord struct {
+	AutonomousSystemNumber uint `maxminddb:"autonomous_system_number"`
+}
+
+// LookupASN returns the autonomous system number for addr, or 0 if the
+// database has no ASN data for it (e.g. a City database rather than an
+// ASN database).
+func (db *DB) LookupASN(addr netip.Addr) (uint, error) {
+	var rec asnRecord
+	if err := db.LookupAddr(addr, &rec); err != nil {
+		return 0, err
+	}
+	return rec.AutonomousSystemNumber, nil
+}
+
+// LookupBatch decodes addrs into out, one result per address, acquiring
+// db.mu.RLock once for the whole batch instead of once per address. This
+// is meant for bulk enrichment passes (e.g. over an access log) where
+// per-address locking and interface-conversion overhead is measurable.
+// len(out) must equal len(addrs).
+//
+// A per-address decode failure (e.g. a malformed netip.Addr) does not
+// abort the batch: it's recorded in errs at the same index, out[i] is
+// left unset, and decoding continues with the remaining addresses. The
+// returned error is non-nil only for a failure that applies to the whole
+// batch (a closed/unopened db, or a length mismatch), in which case errs
+// is nil.
+func LookupBatch[T any](db *DB, addrs []netip.Addr, out []T) (errs []error, err error) {
+	if len(addrs) != len(out) {
+		return nil, fmt.Errorf("freegeoip: LookupBatch: len(addrs)=%d != len(out)=%d", len(addrs), len(out))
+	}
+	start := time.Now()
+	defer func() { db.collector.lookup(err, time.Since(start)) }()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.reader == nil {
+		err = ErrUnavailable
+		return nil, err
+	}
+	for i, addr := range addrs {
+		if lerr := db.reader.Lookup(net.IP(addr.AsSlice()), &out[i]); lerr != nil {
+			if errs == nil {
+				errs = make([]error, len(addrs))
+			}
+			errs[i] = lerr
+		}
+	}
+	return errs, nil
+}
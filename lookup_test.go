@@ -0,0 +1,124 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// buildTestMMDB writes a minimal real .mmdb file to a temp path, mapping
+// network to an ASN record, and returns an opened *maxminddb.Reader for
+// it so tests can exercise real decode paths instead of nil readers.
+func buildTestMMDB(t *testing.T, network string, asn uint32) *maxminddb.Reader {
+	t.Helper()
+	tree, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: "GeoLite2-ASN",
+		Languages:    []string{"en"},
+		RecordSize:   24,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.Insert(ipnet, mmdbtype.Map{
+		"autonomous_system_number": mmdbtype.Uint32(asn),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "lookup-test-*.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tree.WriteTo(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := maxminddb.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return reader
+}
+
+func TestLookupAddrDecodesRealDatabase(t *testing.T) {
+	db := &DB{reader: buildTestMMDB(t, "1.2.3.0/24", 64500)}
+	asn, err := db.LookupASN(netip.MustParseAddr("1.2.3.4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asn != 64500 {
+		t.Fatalf("LookupASN = %d, want 64500", asn)
+	}
+}
+
+func TestLookupASNNoMatch(t *testing.T) {
+	db := &DB{reader: buildTestMMDB(t, "1.2.3.0/24", 64500)}
+	asn, err := db.LookupASN(netip.MustParseAddr("8.8.8.8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asn != 0 {
+		t.Fatalf("LookupASN = %d, want 0 for an address outside the database", asn)
+	}
+}
+
+func TestLookupUnavailable(t *testing.T) {
+	db := &DB{}
+	if err := db.Lookup(net.ParseIP("1.2.3.4"), &struct{}{}); err != ErrUnavailable {
+		t.Fatalf("Lookup = %v, want ErrUnavailable", err)
+	}
+	if err := db.LookupAddr(netip.MustParseAddr("1.2.3.4"), &struct{}{}); err != ErrUnavailable {
+		t.Fatalf("LookupAddr = %v, want ErrUnavailable", err)
+	}
+	if errs, err := LookupBatch(db, []netip.Addr{netip.MustParseAddr("1.2.3.4")}, make([]struct{}, 1)); err != ErrUnavailable || errs != nil {
+		t.Fatalf("LookupBatch = (%v, %v), want (nil, ErrUnavailable)", errs, err)
+	}
+}
+
+func TestLookupBatchLengthMismatch(t *testing.T) {
+	db := &DB{reader: buildTestMMDB(t, "1.2.3.0/24", 64500)}
+	addrs := []netip.Addr{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("8.8.8.8")}
+	_, err := LookupBatch(db, addrs, make([]struct{}, 1))
+	if err == nil {
+		t.Fatal("expected an error for mismatched slice lengths")
+	}
+}
+
+// TestLookupBatchContinuesPastPerAddressError is the regression test for
+// e43fa93: a decode failure for one address (forced here by decoding the
+// ASN record, a map, into a string) must not abort the rest of the batch.
+func TestLookupBatchContinuesPastPerAddressError(t *testing.T) {
+	db := &DB{reader: buildTestMMDB(t, "1.2.3.0/24", 64500)}
+	addrs := []netip.Addr{
+		netip.MustParseAddr("1.2.3.4"), // matches the database -> decode error into a string
+		netip.MustParseAddr("8.8.8.8"), // no match -> no data to decode, succeeds
+	}
+	out := make([]string, len(addrs))
+	errs, err := LookupBatch(db, addrs, out)
+	if err != nil {
+		t.Fatalf("LookupBatch returned a whole-batch error: %v", err)
+	}
+	if errs == nil || errs[0] == nil {
+		t.Fatal("expected a decode error for the matched address")
+	}
+	if errs[1] != nil {
+		t.Fatalf("expected the unmatched address to still succeed, got %v", errs[1])
+	}
+}
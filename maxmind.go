@@ -0,0 +1,147 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxMindMetadataURL is MaxMind's account-based endpoint for querying
+	// the md5 and release date of the editions available for download.
+	maxMindMetadataURL = "https://updates.maxmind.com/geoip/updates/metadata"
+
+	// maxMindDownloadURL is MaxMind's account-based endpoint for
+	// downloading a tar.gz bundle of a given edition.
+	maxMindDownloadURL = "https://updates.maxmind.com/geoip/databases"
+)
+
+// maxMindDefaultDB returns the local cache path OpenMaxMindURL uses, keyed
+// by editionID so that opening
+// more than one edition (e.g. "GeoLite2-City" alongside "GeoLite2-ASN")
+// doesn't have them clobber each other's downloaded file.
+func maxMindDefaultDB(editionID string) string {
+	return filepath.Join(os.TempDir(), "freegeoip", editionID+".mmdb")
+}
+
+// maxMindEdition is a single entry of the metadata endpoint's response.
+type maxMindEdition struct {
+	EditionID string `json:"edition_id"`
+	MD5       string `json:"md5"`
+	Date      string `json:"date"`
+}
+
+type maxMindMetadata struct {
+	Editions []maxMindEdition `json:"editions"`
+}
+
+// maxMindNeedUpdate queries MaxMind's metadata endpoint for editionID and
+// reports whether its md5 differs from the db currently loaded in memory.
+func (db *DB) maxMindNeedUpdate(accountID int, licenseKey, editionID string) (bool, error) {
+	req, err := http.NewRequest("GET", maxMindMetadataURL+"?edition_id="+editionID, nil)
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth(strconv.Itoa(accountID), licenseKey)
+	resp, err := db.httpClientOrDefault().Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrDownloadFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, &ErrHTTPStatus{Code: resp.StatusCode}
+	}
+	var meta maxMindMetadata
+	err = json.NewDecoder(resp.Body).Decode(&meta)
+	if err != nil {
+		return false, err
+	}
+	for _, ed := range meta.Editions {
+		if ed.EditionID != editionID {
+			continue
+		}
+		db.mu.RLock()
+		current := db.md5sum
+		db.mu.RUnlock()
+		return ed.MD5 != current, nil
+	}
+	return false, fmt.Errorf("edition %q not found in maxmind metadata", editionID)
+}
+
+// maxMindDownload fetches the tar.gz bundle for editionID and extracts its
+// .mmdb member into a tempfile, returning its path.
+func (db *DB) maxMindDownload(accountID int, licenseKey, editionID string) (tmpfile string, err error) {
+	db.mu.RLock()
+	currentMD5 := db.md5sum
+	db.mu.RUnlock()
+
+	url := fmt.Sprintf("%s/%s/download?db_md5=%s&suffix=tar.gz",
+		maxMindDownloadURL, editionID, currentMD5)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(strconv.Itoa(accountID), licenseKey)
+	resp, err := db.httpClientOrDefault().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrDownloadFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &ErrHTTPStatus{Code: resp.StatusCode}
+	}
+	return extractMMDB(resp.Body, editionID)
+}
+
+// extractMMDB streams r as a gzip+tar archive and copies the first .mmdb
+// member it finds into a tempfile, returning its path.
+func extractMMDB(r io.Reader, editionID string) (tmpfile string, err error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrArchiveInvalid, err)
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", fmt.Errorf("%w: no .mmdb file found in %s archive", ErrArchiveInvalid, editionID)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrArchiveInvalid, err)
+		}
+		if filepath.Ext(hdr.Name) != ".mmdb" {
+			continue
+		}
+		tmpfile = filepath.Join(os.TempDir(),
+			fmt.Sprintf("_freegeoip.%d.mmdb", time.Now().UnixNano()))
+		f, err := os.Create(tmpfile)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			os.Remove(tmpfile)
+			return "", err
+		}
+		return tmpfile, nil
+	}
+}
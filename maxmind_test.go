@@ -0,0 +1,174 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// rewriteTransport redirects every request to target, regardless of the
+// scheme/host it was built with, so tests can point the MaxMind endpoint
+// consts at an httptest.Server.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func testClient(t *testing.T, server *httptest.Server) *http.Client {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: &rewriteTransport{target: u}}
+}
+
+func TestMaxMindNeedUpdate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"editions":[{"edition_id":"GeoLite2-ASN","md5":"abc123","date":"2024-01-01"}]}`))
+	}))
+	defer server.Close()
+
+	db := &DB{httpClient: testClient(t, server)}
+	yes, err := db.maxMindNeedUpdate(1, "key", "GeoLite2-ASN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !yes {
+		t.Fatal("expected an update to be needed when no edition is loaded yet")
+	}
+
+	db.mu.Lock()
+	db.md5sum = "abc123"
+	db.mu.Unlock()
+	yes, err = db.maxMindNeedUpdate(1, "key", "GeoLite2-ASN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yes {
+		t.Fatal("expected no update to be needed when the md5 already matches")
+	}
+}
+
+func TestMaxMindNeedUpdateNotModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	db := &DB{httpClient: testClient(t, server)}
+	yes, err := db.maxMindNeedUpdate(1, "key", "GeoLite2-ASN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yes {
+		t.Fatal("expected a 304 to mean no update needed")
+	}
+}
+
+// TestMaxMindDownloadNotModified guards against the download endpoint's
+// 304 response being mistaken for an HTTP error: it must return a no-op
+// (empty tmpfile, nil error) just like needUpdate does.
+func TestMaxMindDownloadNotModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	db := &DB{httpClient: testClient(t, server)}
+	tmpfile, err := db.maxMindDownload(1, "key", "GeoLite2-ASN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpfile != "" {
+		os.Remove(tmpfile)
+		t.Fatal("expected no tempfile on a 304 response")
+	}
+}
+
+func TestMaxMindDownloadStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	db := &DB{httpClient: testClient(t, server)}
+	_, err := db.maxMindDownload(1, "key", "GeoLite2-ASN")
+	var statusErr *ErrHTTPStatus
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected *ErrHTTPStatus, got %v", err)
+	}
+	if statusErr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", statusErr.Code)
+	}
+}
+
+func TestMaxMindDownloadExtractsMMDB(t *testing.T) {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	content := []byte("fake mmdb content")
+	if err := tw.WriteHeader(&tar.Header{Name: "GeoLite2-ASN.mmdb", Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	db := &DB{httpClient: testClient(t, server)}
+	tmpfile, err := db.maxMindDownload(1, "key", "GeoLite2-ASN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile)
+
+	got, err := os.ReadFile(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("extracted content = %q, want %q", got, content)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&ErrHTTPStatus{Code: http.StatusNotFound}, true},
+		{&ErrHTTPStatus{Code: http.StatusInternalServerError}, false},
+		{&ErrHTTPStatus{Code: http.StatusNotModified}, false},
+		{errors.New("not a temporaryError"), false},
+	}
+	for _, c := range cases {
+		if got := isPermanent(c.err); got != c.want {
+			t.Errorf("isPermanent(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// dbMeta is the sidecar record persisted next to a DB's file so a
+// restart can resume conditional requests without redownloading.
+type dbMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+}
+
+// metaFile returns the path of db's sidecar .meta file.
+func (db *DB) metaFile() string {
+	return db.file + ".meta"
+}
+
+// loadMeta restores etag/lastModified/sha256sum from the sidecar .meta
+// file, if one exists. It's best-effort: a missing or corrupt file just
+// means the next update runs unconditionally.
+func (db *DB) loadMeta() {
+	b, err := ioutil.ReadFile(db.metaFile())
+	if err != nil {
+		return
+	}
+	var m dbMeta
+	if json.Unmarshal(b, &m) != nil {
+		return
+	}
+	db.mu.Lock()
+	db.etag = m.ETag
+	db.lastModified = m.LastModified
+	db.sha256sum = m.SHA256
+	db.mu.Unlock()
+}
+
+// saveMeta persists etag/lastModified/sha256sum to the sidecar .meta file.
+func (db *DB) saveMeta() error {
+	db.mu.RLock()
+	m := dbMeta{
+		ETag:         db.etag,
+		LastModified: db.lastModified,
+		SHA256:       db.sha256sum,
+	}
+	db.mu.RUnlock()
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(db.metaFile(), b, 0644)
+}
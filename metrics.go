@@ -0,0 +1,117 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector exposes Prometheus metrics for a DB's background reload and
+// Lookup activity. Register it with prometheus.MustRegister (or a custom
+// registry) and pass it to a DB via WithCollector to have it report:
+//
+//	freegeoip_db_last_reload_timestamp_seconds
+//	freegeoip_db_reload_total{result="ok|error"}
+//	freegeoip_db_download_bytes_total
+//	freegeoip_db_download_duration_seconds
+//	freegeoip_db_lookup_total{result="ok|error"}
+//	freegeoip_db_lookup_duration_seconds
+type Collector struct {
+	lastReload      prometheus.Gauge
+	reloadTotal     *prometheus.CounterVec
+	downloadBytes   prometheus.Counter
+	downloadSeconds prometheus.Histogram
+	lookupTotal     *prometheus.CounterVec
+	lookupSeconds   prometheus.Histogram
+}
+
+// NewCollector creates a Collector. A nil *Collector is valid and simply
+// reports nothing, so DB's internal instrumentation calls need not guard
+// against a DB opened without WithCollector.
+func NewCollector() *Collector {
+	return &Collector{
+		lastReload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "freegeoip_db_last_reload_timestamp_seconds",
+			Help: "Unix timestamp of the last successful database reload.",
+		}),
+		reloadTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "freegeoip_db_reload_total",
+			Help: "Count of database reload attempts, by result.",
+		}, []string{"result"}),
+		downloadBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "freegeoip_db_download_bytes_total",
+			Help: "Total bytes downloaded while updating the database.",
+		}),
+		downloadSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "freegeoip_db_download_duration_seconds",
+			Help: "Duration of database download requests.",
+		}),
+		lookupTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "freegeoip_db_lookup_total",
+			Help: "Count of Lookup calls, by result.",
+		}, []string{"result"}),
+		lookupSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "freegeoip_db_lookup_duration_seconds",
+			Help: "Duration of Lookup calls.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.lastReload.Describe(ch)
+	c.reloadTotal.Describe(ch)
+	c.downloadBytes.Describe(ch)
+	c.downloadSeconds.Describe(ch)
+	c.lookupTotal.Describe(ch)
+	c.lookupSeconds.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.lastReload.Collect(ch)
+	c.reloadTotal.Collect(ch)
+	c.downloadBytes.Collect(ch)
+	c.downloadSeconds.Collect(ch)
+	c.lookupTotal.Collect(ch)
+	c.lookupSeconds.Collect(ch)
+}
+
+func (c *Collector) reloadOK(at time.Time) {
+	if c == nil {
+		return
+	}
+	c.lastReload.Set(float64(at.Unix()))
+	c.reloadTotal.WithLabelValues("ok").Inc()
+}
+
+func (c *Collector) reloadError() {
+	if c == nil {
+		return
+	}
+	c.reloadTotal.WithLabelValues("error").Inc()
+}
+
+func (c *Collector) downloaded(bytes int64, d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.downloadBytes.Add(float64(bytes))
+	c.downloadSeconds.Observe(d.Seconds())
+}
+
+func (c *Collector) lookup(err error, d time.Duration) {
+	if c == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	c.lookupTotal.WithLabelValues(result).Inc()
+	c.lookupSeconds.Observe(d.Seconds())
+}
@@ -0,0 +1,383 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/howeyc/fsnotify"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// knownEditions lists the GeoIP2/GeoLite2 edition IDs that MultiDB
+// auto-discovers in its directory, by the .mmdb file name MaxMind ships
+// them under.
+var knownEditions = []string{
+	"GeoLite2-City",
+	"GeoLite2-Country",
+	"GeoLite2-ASN",
+	"GeoIP2-City",
+	"GeoIP2-Country",
+	"GeoIP2-ISP",
+	"GeoIP2-Connection-Type",
+}
+
+// editionDB is a single database tracked by a MultiDB. It has its own
+// lock and reader so reloading one edition never blocks a Lookup against
+// another.
+type editionDB struct {
+	editionID   string
+	file        string
+	reader      *maxminddb.Reader
+	lastUpdated time.Time
+	mu          sync.RWMutex
+}
+
+// EditionEvent reports that an edition's database has been (re)loaded.
+type EditionEvent struct {
+	EditionID string
+	File      string
+}
+
+// EditionError wraps an error that occurred while loading or reloading a
+// specific edition's database, so callers of NotifyError can tell which
+// edition failed.
+type EditionError struct {
+	EditionID string
+	Err       error
+}
+
+func (e *EditionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.EditionID, e.Err)
+}
+
+func (e *EditionError) Unwrap() error {
+	return e.Err
+}
+
+// MultiDB manages a directory containing more than one MaxMind database
+// (Country, City, ASN, ISP, Connection-Type, ...), so a single Lookup can
+// be enriched with data from all of them. Each database is loaded and
+// reloaded independently of the others.
+type MultiDB struct {
+	dir         string
+	dbs         map[string]*editionDB
+	notifyQuit  chan struct{}
+	notifyOpen  chan EditionEvent
+	notifyError chan error
+	closed      bool
+	mu          sync.RWMutex // Protects dbs and closed.
+}
+
+// OpenMultiDB creates a MultiDB that loads every known edition's .mmdb
+// file already present in dir, and watches dir for new files and for
+// changes to the ones already loaded. A corrupt or unreadable file for
+// one edition is reported through NotifyError and doesn't prevent the
+// other editions from loading.
+func OpenMultiDB(dir string) (mdb *MultiDB, err error) {
+	mdb = &MultiDB{
+		dir:         dir,
+		dbs:         make(map[string]*editionDB),
+		notifyQuit:  make(chan struct{}),
+		notifyOpen:  make(chan EditionEvent, 1),
+		notifyError: make(chan error, 1),
+	}
+	mdb.scan()
+	err = mdb.watchDir()
+	if err != nil {
+		mdb.Close()
+		return nil, fmt.Errorf("fsnotify failed for %s: %s", dir, err)
+	}
+	return mdb, nil
+}
+
+// scan loads every known edition whose .mmdb file already exists in dir.
+// A load failure for one edition is reported via sendError instead of
+// aborting the scan, so the rest of the editions still load, the same
+// isolation handleEvent gives a failure discovered at runtime.
+func (mdb *MultiDB) scan() {
+	for _, editionID := range knownEditions {
+		file := filepath.Join(mdb.dir, editionID+".mmdb")
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if err := mdb.loadEdition(editionID, file); err != nil {
+			mdb.sendError(editionID, err)
+		}
+	}
+}
+
+func (mdb *MultiDB) watchDir() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = watcher.Watch(mdb.dir)
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	go mdb.watchEvents(watcher)
+	return nil
+}
+
+func (mdb *MultiDB) watchEvents(watcher *fsnotify.Watcher) {
+	timer := time.NewTimer(watchDebounce)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	pending := make(map[string]bool)
+	for {
+		select {
+		case ev := <-watcher.Event:
+			if ev.IsCreate() || ev.IsModify() {
+				pending[ev.Name] = true
+				timer.Reset(watchDebounce)
+			}
+		case <-watcher.Error:
+		case <-timer.C:
+			for name := range pending {
+				mdb.handleEvent(name)
+			}
+			pending = make(map[string]bool)
+		case <-mdb.notifyQuit:
+			watcher.Close()
+			return
+		}
+	}
+}
+
+func (mdb *MultiDB) handleEvent(name string) {
+	editionID, ok := editionIDForFile(name)
+	if !ok {
+		return
+	}
+	err := mdb.loadEdition(editionID, name)
+	if err != nil {
+		mdb.sendError(editionID, err)
+	}
+}
+
+// editionIDForFile reports the known edition ID a .mmdb file name
+// corresponds to, if any.
+func editionIDForFile(name string) (editionID string, ok bool) {
+	base := filepath.Base(name)
+	if filepath.Ext(base) != ".mmdb" {
+		return "", false
+	}
+	editionID = strings.TrimSuffix(base, ".mmdb")
+	for _, known := range knownEditions {
+		if known == editionID {
+			return editionID, true
+		}
+	}
+	return "", false
+}
+
+// loadEdition (re)loads the database for editionID from file, replacing
+// any reader previously held for that edition.
+func (mdb *MultiDB) loadEdition(editionID, file string) error {
+	reader, err := openMMDBFile(file)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+
+	mdb.mu.Lock()
+	if mdb.closed {
+		mdb.mu.Unlock()
+		reader.Close()
+		return nil
+	}
+	ed, ok := mdb.dbs[editionID]
+	if !ok {
+		ed = &editionDB{editionID: editionID, file: file}
+		mdb.dbs[editionID] = ed
+	}
+	mdb.mu.Unlock()
+
+	ed.mu.Lock()
+	if ed.reader != nil {
+		ed.reader.Close()
+	}
+	ed.reader = reader
+	ed.lastUpdated = stat.ModTime().UTC()
+	ed.mu.Unlock()
+
+	mdb.sendOpen(editionID, file)
+	return nil
+}
+
+func (mdb *MultiDB) sendOpen(editionID, file string) {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	if mdb.closed {
+		return
+	}
+	select {
+	case mdb.notifyOpen <- EditionEvent{EditionID: editionID, File: file}:
+	default:
+	}
+}
+
+func (mdb *MultiDB) sendError(editionID string, err error) {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	if mdb.closed {
+		return
+	}
+	select {
+	case mdb.notifyError <- &EditionError{EditionID: editionID, Err: err}:
+	default:
+	}
+}
+
+// lookup decodes addr's record from the first of editionIDs that's
+// loaded into result. It returns ErrUnavailable if none of them is.
+func (mdb *MultiDB) lookup(addr net.IP, result interface{}, editionIDs ...string) error {
+	for _, editionID := range editionIDs {
+		mdb.mu.RLock()
+		ed, ok := mdb.dbs[editionID]
+		mdb.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		ed.mu.RLock()
+		reader := ed.reader
+		ed.mu.RUnlock()
+		if reader == nil {
+			continue
+		}
+		return reader.Lookup(addr, result)
+	}
+	return ErrUnavailable
+}
+
+// LookupCity decodes addr's City record into result, preferring the free
+// GeoLite2-City edition and falling back to the commercial GeoIP2-City
+// edition if that one isn't loaded.
+func (mdb *MultiDB) LookupCity(addr net.IP, result interface{}) error {
+	return mdb.lookup(addr, result, "GeoLite2-City", "GeoIP2-City")
+}
+
+// LookupCountry decodes addr's Country record into result, preferring
+// the free GeoLite2-Country edition and falling back to the commercial
+// GeoIP2-Country edition if that one isn't loaded.
+func (mdb *MultiDB) LookupCountry(addr net.IP, result interface{}) error {
+	return mdb.lookup(addr, result, "GeoLite2-Country", "GeoIP2-Country")
+}
+
+// LookupASN decodes the GeoLite2-ASN record for addr into result.
+func (mdb *MultiDB) LookupASN(addr net.IP, result interface{}) error {
+	return mdb.lookup(addr, result, "GeoLite2-ASN")
+}
+
+// LookupISP decodes the commercial GeoIP2-ISP record for addr into
+// result. There is no free GeoLite2 equivalent.
+func (mdb *MultiDB) LookupISP(addr net.IP, result interface{}) error {
+	return mdb.lookup(addr, result, "GeoIP2-ISP")
+}
+
+// LookupConnectionType decodes the commercial GeoIP2-Connection-Type
+// record for addr into result. There is no free GeoLite2 equivalent.
+func (mdb *MultiDB) LookupConnectionType(addr net.IP, result interface{}) error {
+	return mdb.lookup(addr, result, "GeoIP2-Connection-Type")
+}
+
+// Lookup decodes addr's record from every edition currently loaded into
+// result, so a single struct can be populated with fields coming from
+// more than one database (e.g. country from GeoLite2-Country plus asn
+// from GeoLite2-ASN). It returns ErrUnavailable if no edition is loaded.
+func (mdb *MultiDB) Lookup(addr net.IP, result interface{}) error {
+	dbs := mdb.loadedEditionsInOrder()
+	if len(dbs) == 0 {
+		return ErrUnavailable
+	}
+	found := false
+	for _, ed := range dbs {
+		ed.mu.RLock()
+		reader := ed.reader
+		ed.mu.RUnlock()
+		if reader == nil {
+			continue
+		}
+		found = true
+		err := reader.Lookup(addr, result)
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// loadedEditionsInOrder returns the editionDBs present in mdb.dbs, in
+// knownEditions order rather than map iteration order, so callers that
+// merge across editions (like Lookup) get stable, reproducible
+// precedence between runs.
+func (mdb *MultiDB) loadedEditionsInOrder() []*editionDB {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	dbs := make([]*editionDB, 0, len(mdb.dbs))
+	for _, editionID := range knownEditions {
+		if ed, ok := mdb.dbs[editionID]; ok {
+			dbs = append(dbs, ed)
+		}
+	}
+	return dbs
+}
+
+// NotifyClose returns a channel that is closed when the MultiDB is closed.
+func (mdb *MultiDB) NotifyClose() <-chan struct{} {
+	return mdb.notifyQuit
+}
+
+// NotifyOpen returns a channel that notifies when an edition's database
+// is loaded or reloaded.
+func (mdb *MultiDB) NotifyOpen() <-chan EditionEvent {
+	return mdb.notifyOpen
+}
+
+// NotifyError returns a channel that notifies, as an *EditionError, when
+// an error occurs while loading or reloading one of the databases.
+func (mdb *MultiDB) NotifyError() <-chan error {
+	return mdb.notifyError
+}
+
+// Close the MultiDB and every database it holds.
+func (mdb *MultiDB) Close() {
+	mdb.mu.Lock()
+	if mdb.closed {
+		mdb.mu.Unlock()
+		return
+	}
+	mdb.closed = true
+	close(mdb.notifyQuit)
+	close(mdb.notifyOpen)
+	close(mdb.notifyError)
+	dbs := mdb.dbs
+	mdb.mu.Unlock()
+
+	for _, ed := range dbs {
+		ed.mu.Lock()
+		if ed.reader != nil {
+			ed.reader.Close()
+			ed.reader = nil
+		}
+		ed.mu.Unlock()
+	}
+}
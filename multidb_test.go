@@ -0,0 +1,102 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestLoadedEditionsInOrder is the regression test for f84fb92: editions
+// must come back in knownEditions order, not Go's randomized map
+// iteration order, so MultiDB.Lookup merges them with stable precedence.
+func TestLoadedEditionsInOrder(t *testing.T) {
+	mdb := &MultiDB{dbs: map[string]*editionDB{
+		"GeoIP2-City":      {editionID: "GeoIP2-City"},
+		"GeoLite2-ASN":     {editionID: "GeoLite2-ASN"},
+		"GeoLite2-City":    {editionID: "GeoLite2-City"},
+		"GeoLite2-Country": {editionID: "GeoLite2-Country"},
+	}}
+	dbs := mdb.loadedEditionsInOrder()
+
+	var got []string
+	for _, ed := range dbs {
+		got = append(got, ed.editionID)
+	}
+	want := []string{"GeoLite2-City", "GeoLite2-Country", "GeoLite2-ASN", "GeoIP2-City"}
+	if len(got) != len(want) {
+		t.Fatalf("loadedEditionsInOrder() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("loadedEditionsInOrder() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMultiDBLookupUnavailable(t *testing.T) {
+	mdb := &MultiDB{dbs: make(map[string]*editionDB)}
+	addr := netip.MustParseAddr("1.2.3.4").AsSlice()
+	if err := mdb.Lookup(addr, &struct{}{}); err != ErrUnavailable {
+		t.Fatalf("Lookup = %v, want ErrUnavailable", err)
+	}
+	if err := mdb.LookupCity(addr, &struct{}{}); err != ErrUnavailable {
+		t.Fatalf("LookupCity = %v, want ErrUnavailable", err)
+	}
+	if err := mdb.LookupISP(addr, &struct{}{}); err != ErrUnavailable {
+		t.Fatalf("LookupISP = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestMultiDBLookupCityFallsBackToGeoIP2(t *testing.T) {
+	reader := buildTestMMDB(t, "1.2.3.0/24", 64500)
+	mdb := &MultiDB{dbs: map[string]*editionDB{
+		// Only the commercial edition is loaded; LookupCity must still
+		// find it rather than returning ErrUnavailable.
+		"GeoIP2-City": {editionID: "GeoIP2-City", reader: reader},
+	}}
+	var rec struct {
+		ASN uint `maxminddb:"autonomous_system_number"`
+	}
+	if err := mdb.LookupCity(netip.MustParseAddr("1.2.3.4").AsSlice(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.ASN != 64500 {
+		t.Fatalf("LookupCity decoded ASN = %d, want 64500", rec.ASN)
+	}
+}
+
+// TestOpenMultiDBIsolatesCorruptEdition is the regression test for the
+// OpenMultiDB/scan isolation fix: a corrupt .mmdb file for one edition
+// must be reported through NotifyError as that edition's error instead of
+// failing OpenMultiDB as a whole.
+func TestOpenMultiDBIsolatesCorruptEdition(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "GeoLite2-City.mmdb"), []byte("not a real mmdb file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mdb, err := OpenMultiDB(dir)
+	if err != nil {
+		t.Fatalf("OpenMultiDB returned an error for a single corrupt edition: %v", err)
+	}
+	defer mdb.Close()
+
+	select {
+	case notifyErr := <-mdb.NotifyError():
+		var editionErr *EditionError
+		if ee, ok := notifyErr.(*EditionError); ok {
+			editionErr = ee
+		}
+		if editionErr == nil || editionErr.EditionID != "GeoLite2-City" {
+			t.Fatalf("NotifyError() = %v, want an *EditionError for GeoLite2-City", notifyErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected NotifyError to report the corrupt GeoLite2-City edition")
+	}
+}
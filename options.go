@@ -0,0 +1,56 @@
+// Copyright 2009-2014 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package freegeoip
+
+import "net/http"
+
+// Option configures optional behavior for OpenURL and OpenMaxMindURL.
+type Option func(*DB)
+
+// WithHTTPClient overrides the *http.Client used for update requests.
+// The default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(db *DB) {
+		db.httpClient = client
+	}
+}
+
+// WithArchiveFormat overrides auto-detection of the downloaded file's
+// archive format. Use this when the origin doesn't serve recognizable
+// magic bytes, or to skip the sniffing.
+func WithArchiveFormat(format Format) Option {
+	return func(db *DB) {
+		db.archiveFormat = format
+	}
+}
+
+// WithChecksum verifies every file downloaded by OpenURL against an
+// expected digest before it's installed, rejecting the download if it
+// doesn't match. expectedHex is the hex-encoded digest computed with algo.
+// OpenMaxMindURL accepts the option but does not verify its downloads.
+func WithChecksum(algo ChecksumAlgo, expectedHex string) Option {
+	return func(db *DB) {
+		db.checksum = &checksumConfig{algo: algo, expectedHex: expectedHex}
+	}
+}
+
+// WithSHA256URL verifies every file downloaded by OpenURL against a
+// SHA-256 digest fetched from digestURL (conventionally the database URL
+// with a ".sha256" suffix) before it's installed. OpenMaxMindURL accepts
+// the option but does not verify its downloads.
+func WithSHA256URL(digestURL string) Option {
+	return func(db *DB) {
+		db.checksum = &checksumConfig{algo: ChecksumSHA256, digestURL: digestURL}
+	}
+}
+
+// WithCollector wires db up to report Prometheus metrics through c. Use
+// prometheus.MustRegister(c) (or an equivalent custom registry) to expose
+// them.
+func WithCollector(c *Collector) Option {
+	return func(db *DB) {
+		db.collector = c
+	}
+}